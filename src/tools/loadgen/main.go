@@ -0,0 +1,336 @@
+// loadgen: a tool that pushes a reproducible mix of envelopes into doppler's
+// gRPC ingress at a target rate and reports how many of them doppler
+// delivered to its firehose subscribers.
+//
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"code.cloudfoundry.org/loggregator/plumbing"
+)
+
+var (
+	addr     = flag.String("addr", "localhost:8082", "the host:port of doppler's gRPC ingress")
+	certFile = flag.String("cert", "", "cert to use to connect to doppler")
+	keyFile  = flag.String("key", "", "key to use to connect to doppler")
+	caFile   = flag.String("ca", "", "ca cert to use to connect to doppler")
+	rate     = flag.Int("rate", 1000, "envelopes per second to send")
+	duration = flag.Duration("duration", time.Minute, "how long to send envelopes for")
+	mix      = flag.String("mix", "log=70,counter=10,value=10,container=10", "relative weights of envelope types")
+	apps     = flag.Int("apps", 10, "number of distinct app IDs to emit for")
+	logSize  = flag.Int("log-size", 256, "size in bytes of each log message")
+	seed     = flag.Int64("seed", 1, "seed for the envelope sequence, the same seed always yields the same mix")
+
+	subscribers = flag.Int("subscribers", 1, "number of firehose subscriptions to open on doppler, 0 only generates load")
+	drain       = flag.Duration("drain", 5*time.Second, "how long to keep counting delivered envelopes after sending stops")
+)
+
+const (
+	// ticksPerSecond is how often a batch of envelopes is sent to reach the
+	// target rate.
+	ticksPerSecond = 100
+
+	// origin marks the envelopes sent by loadgen so subscribers can tell
+	// them apart from everything else on the firehose.
+	origin = "loadgen"
+)
+
+func main() {
+	flag.Parse()
+
+	if *rate <= 0 {
+		log.Fatalf("invalid rate %d: must be greater than zero", *rate)
+	}
+	if *apps <= 0 {
+		log.Fatalf("invalid apps %d: must be greater than zero", *apps)
+	}
+	if *logSize < 0 {
+		log.Fatalf("invalid log-size %d: must not be negative", *logSize)
+	}
+	if *subscribers < 0 {
+		log.Fatalf("invalid subscribers %d: must not be negative", *subscribers)
+	}
+
+	gens, err := parseMix(*mix)
+	if err != nil {
+		log.Fatalf("invalid mix: %s", err)
+	}
+
+	creds, err := plumbing.NewClientCredentials(
+		*certFile,
+		*keyFile,
+		*caFile,
+		"doppler",
+	)
+	if err != nil {
+		log.Fatalf("failed to load client credentials: %s", err)
+	}
+
+	conn, err := grpc.Dial(*addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		log.Fatalf("failed to dial doppler: %s", err)
+	}
+	defer conn.Close()
+
+	pusher, err := plumbing.NewDopplerIngestorClient(conn).Pusher(context.Background())
+	if err != nil {
+		log.Fatalf("failed to open pusher stream: %s", err)
+	}
+
+	// Each subscription uses its own shard ID so doppler sends every
+	// envelope to all of them.
+	subs := make([]*subscriber, *subscribers)
+	for i := range subs {
+		subs[i] = subscribe(plumbing.NewDopplerClient(conn), fmt.Sprintf("loadgen-%d", i))
+	}
+	if len(subs) > 0 {
+		// Give doppler time to register the subscriptions so the first
+		// envelopes sent are not counted as lost.
+		time.Sleep(time.Second)
+	}
+
+	appIDs := make([]string, *apps)
+	for i := range appIDs {
+		appIDs[i] = fmt.Sprintf("loadgen-app-%d", i)
+	}
+	msg := []byte(strings.Repeat("J", *logSize))
+	r := rand.New(rand.NewSource(*seed))
+
+	ticker := time.NewTicker(time.Second / ticksPerSecond)
+	defer ticker.Stop()
+	done := time.After(*duration)
+	start := time.Now()
+
+	var sent, owed int
+sendLoop:
+	for {
+		select {
+		case <-done:
+			break sendLoop
+		case <-ticker.C:
+			// Carry the remainder between ticks so rates that are not a
+			// multiple of ticksPerSecond are still met over a second.
+			owed += *rate
+			n := owed / ticksPerSecond
+			owed %= ticksPerSecond
+
+			for i := 0; i < n; i++ {
+				appID := appIDs[r.Intn(len(appIDs))]
+				e := gens.pick(r)(appID, msg, r)
+				data, err := proto.Marshal(e)
+				if err != nil {
+					log.Fatalf("error while marshalling: %s", err)
+				}
+
+				err = pusher.Send(&plumbing.EnvelopeData{Payload: data})
+				if err != nil {
+					log.Fatalf("failed to send envelope: %s", err)
+				}
+				sent++
+			}
+		}
+	}
+
+	elapsed := time.Since(start)
+	log.Printf(
+		"sent %d envelopes in %s (%.0f/s)",
+		sent,
+		elapsed,
+		float64(sent)/elapsed.Seconds(),
+	)
+	if len(subs) == 0 || sent == 0 {
+		return
+	}
+
+	time.Sleep(*drain)
+	for i, s := range subs {
+		received, rate := s.stats(start)
+		log.Printf(
+			"subscriber %d received %d of %d envelopes (%.2f%% loss, %.0f/s)",
+			i,
+			received,
+			sent,
+			100*(1-float64(received)/float64(sent)),
+			rate,
+		)
+	}
+}
+
+// subscriber counts the envelopes sent by loadgen that doppler delivers on
+// one firehose subscription.
+type subscriber struct {
+	received int64
+	last     int64 // time of the last delivery in nanoseconds
+}
+
+func subscribe(c plumbing.DopplerClient, shardID string) *subscriber {
+	stream, err := c.Subscribe(
+		context.Background(),
+		&plumbing.SubscriptionRequest{ShardID: shardID},
+	)
+	if err != nil {
+		log.Fatalf("failed to subscribe as %s: %s", shardID, err)
+	}
+
+	s := &subscriber{}
+	go s.count(stream)
+	return s
+}
+
+func (s *subscriber) count(stream plumbing.Doppler_SubscribeClient) {
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			log.Printf("subscription closed: %s", err)
+			return
+		}
+
+		var e events.Envelope
+		err = proto.Unmarshal(resp.GetPayload(), &e)
+		if err != nil || e.GetOrigin() != origin {
+			continue
+		}
+
+		atomic.AddInt64(&s.received, 1)
+		atomic.StoreInt64(&s.last, time.Now().UnixNano())
+	}
+}
+
+// stats returns the number of envelopes received and the rate they were
+// received at between start and the last delivery.
+func (s *subscriber) stats(start time.Time) (int64, float64) {
+	received := atomic.LoadInt64(&s.received)
+	if received == 0 {
+		return 0, 0
+	}
+
+	last := time.Unix(0, atomic.LoadInt64(&s.last))
+	return received, float64(received) / last.Sub(start).Seconds()
+}
+
+type generator func(appID string, msg []byte, r *rand.Rand) *events.Envelope
+
+var generators = map[string]generator{
+	"log":       logMessage,
+	"counter":   counterEvent,
+	"value":     valueMetric,
+	"container": containerMetric,
+}
+
+type weightedGenerator struct {
+	weight int
+	gen    generator
+}
+
+type mixture struct {
+	total int
+	gens  []weightedGenerator
+}
+
+// parseMix parses a comma separated list of type=weight pairs,
+// e.g. "log=70,counter=30".
+func parseMix(s string) (mixture, error) {
+	var m mixture
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return mixture{}, fmt.Errorf("%q is not of the form type=weight", pair)
+		}
+
+		gen, ok := generators[kv[0]]
+		if !ok {
+			return mixture{}, fmt.Errorf("unknown envelope type %q", kv[0])
+		}
+
+		weight, err := strconv.Atoi(kv[1])
+		if err != nil || weight < 0 {
+			return mixture{}, fmt.Errorf("invalid weight %q for %s", kv[1], kv[0])
+		}
+
+		m.total += weight
+		m.gens = append(m.gens, weightedGenerator{weight: weight, gen: gen})
+	}
+
+	if m.total == 0 {
+		return mixture{}, fmt.Errorf("weights must sum to more than zero")
+	}
+
+	return m, nil
+}
+
+func (m mixture) pick(r *rand.Rand) generator {
+	n := r.Intn(m.total)
+	for _, g := range m.gens {
+		if n < g.weight {
+			return g.gen
+		}
+		n -= g.weight
+	}
+	panic("unreachable")
+}
+
+func newEnvelope(t events.Envelope_EventType) *events.Envelope {
+	return &events.Envelope{
+		Origin:    proto.String(origin),
+		EventType: t.Enum(),
+		Timestamp: proto.Int64(time.Now().UnixNano()),
+	}
+}
+
+func logMessage(appID string, msg []byte, r *rand.Rand) *events.Envelope {
+	e := newEnvelope(events.Envelope_LogMessage)
+	e.LogMessage = &events.LogMessage{
+		Message:        msg,
+		MessageType:    events.LogMessage_OUT.Enum(),
+		Timestamp:      e.Timestamp,
+		AppId:          proto.String(appID),
+		SourceType:     proto.String("APP"),
+		SourceInstance: proto.String(strconv.Itoa(r.Intn(4))),
+	}
+	return e
+}
+
+func counterEvent(_ string, _ []byte, r *rand.Rand) *events.Envelope {
+	e := newEnvelope(events.Envelope_CounterEvent)
+	e.CounterEvent = &events.CounterEvent{
+		Name:  proto.String("loadgen.counter"),
+		Delta: proto.Uint64(uint64(r.Intn(10))),
+		Total: proto.Uint64(0),
+	}
+	return e
+}
+
+func valueMetric(_ string, _ []byte, r *rand.Rand) *events.Envelope {
+	e := newEnvelope(events.Envelope_ValueMetric)
+	e.ValueMetric = &events.ValueMetric{
+		Name:  proto.String("loadgen.value"),
+		Value: proto.Float64(r.Float64()),
+		Unit:  proto.String("gauge"),
+	}
+	return e
+}
+
+func containerMetric(appID string, _ []byte, r *rand.Rand) *events.Envelope {
+	e := newEnvelope(events.Envelope_ContainerMetric)
+	e.ContainerMetric = &events.ContainerMetric{
+		ApplicationId: proto.String(appID),
+		InstanceIndex: proto.Int32(int32(r.Intn(4))),
+		CpuPercentage: proto.Float64(r.Float64() * 100),
+		MemoryBytes:   proto.Uint64(uint64(r.Int63n(1 << 30))),
+		DiskBytes:     proto.Uint64(uint64(r.Int63n(1 << 30))),
+	}
+	return e
+}
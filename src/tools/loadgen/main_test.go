@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math/rand"
+
+	"github.com/cloudfoundry/sonde-go/events"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Mix", func() {
+	Describe("parseMix", func() {
+		It("parses type=weight pairs", func() {
+			m, err := parseMix("log=70,counter=30")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.total).To(Equal(100))
+			Expect(m.gens).To(HaveLen(2))
+		})
+
+		It("rejects pairs without a weight", func() {
+			_, err := parseMix("log")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects unknown envelope types", func() {
+			_, err := parseMix("log=70,error=30")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects non-numeric weights", func() {
+			_, err := parseMix("log=lots")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects negative weights", func() {
+			_, err := parseMix("log=70,counter=-1")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects weights that sum to zero", func() {
+			_, err := parseMix("log=0,counter=0")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("pick", func() {
+		pickTypes := func(m mixture, seed int64, n int) []events.Envelope_EventType {
+			r := rand.New(rand.NewSource(seed))
+			types := make([]events.Envelope_EventType, n)
+			for i := range types {
+				types[i] = m.pick(r)("app-id", nil, r).GetEventType()
+			}
+			return types
+		}
+
+		It("yields the same sequence for the same seed", func() {
+			m, err := parseMix("log=50,counter=20,value=20,container=10")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(pickTypes(m, 42, 1000)).To(Equal(pickTypes(m, 42, 1000)))
+		})
+
+		It("picks types in proportion to their weights", func() {
+			m, err := parseMix("log=70,counter=30,value=0")
+			Expect(err).ToNot(HaveOccurred())
+
+			counts := make(map[events.Envelope_EventType]int)
+			for _, t := range pickTypes(m, 1, 10000) {
+				counts[t]++
+			}
+
+			Expect(counts[events.Envelope_LogMessage]).To(BeNumerically("~", 7000, 300))
+			Expect(counts[events.Envelope_CounterEvent]).To(BeNumerically("~", 3000, 300))
+			Expect(counts).ToNot(HaveKey(events.Envelope_ValueMetric))
+		})
+	})
+})